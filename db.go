@@ -0,0 +1,163 @@
+package main
+
+import "fmt"
+
+// checkpointEvery bounds how many WAL-logged mutations accumulate before a
+// checkpoint flushes the superblock and truncates the log.
+const checkpointEvery = 128
+
+// DB wraps a pager-backed BTree with a write-ahead log so multi-key
+// mutations are atomic and crash-safe: Commit logs the whole transaction
+// (every op plus a trailing commit marker) in a single fsynced write, and
+// a crash before the next checkpoint is recovered by replaying the log on
+// Open, discarding any transaction whose commit marker didn't make it in.
+type DB struct {
+	pager *Pager
+	wal   *WAL
+
+	nextTxnID       uint64
+	sinceCheckpoint int
+}
+
+func Open(path string) (*DB, error) {
+	pager, err := OpenPager(path)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := OpenWAL(path + ".wal")
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+
+	db := &DB{pager: pager, wal: wal}
+	if err := db.recover(); err != nil {
+		wal.Close()
+		pager.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// recover replays every committed transaction left over from an unclean
+// shutdown (commit lsn > the superblock's checkpoint lsn) and, if
+// anything was replayed, immediately checkpoints so the WAL doesn't grow
+// unbounded across repeated crashes.
+func (db *DB) recover() error {
+	tree := db.pager.Tree()
+	lastLSN, err := db.wal.Replay(db.pager.CheckpointLSN(), func(rec walRecord) {
+		switch rec.op {
+		case WAL_OP_SET:
+			tree.Insert(rec.key, rec.val)
+		case WAL_OP_DEL:
+			tree.Delete(rec.key)
+		}
+		if rec.txnID >= db.nextTxnID {
+			db.nextTxnID = rec.txnID
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if lastLSN > db.pager.CheckpointLSN() {
+		return db.checkpoint(lastLSN)
+	}
+	return nil
+}
+
+// checkpoint publishes the current tree root (and free-list state) in the
+// superblock and truncates the WAL, since every mutation up to lsn is now
+// durable in the pages themselves.
+func (db *DB) checkpoint(lsn uint64) error {
+	db.pager.checkpointLSN = lsn
+	if err := db.pager.Flush(); err != nil {
+		return fmt.Errorf("db: checkpoint: %w", err)
+	}
+	if err := db.wal.Truncate(); err != nil {
+		return fmt.Errorf("db: checkpoint: %w", err)
+	}
+	db.sinceCheckpoint = 0
+	return nil
+}
+
+func (db *DB) Close() error {
+	if err := db.pager.Flush(); err != nil {
+		return err
+	}
+	if err := db.wal.Close(); err != nil {
+		return err
+	}
+	return db.pager.Close()
+}
+
+// pendingOp is one mutation buffered in a Tx until Commit.
+type pendingOp struct {
+	op  uint8
+	key []byte
+	val []byte
+}
+
+// Tx is an atomic batch of Set/Del mutations. Nothing is visible to the
+// tree (or durable) until Commit.
+type Tx struct {
+	db   *DB
+	id   uint64
+	ops  []pendingOp
+	done bool
+}
+
+// Begin starts a new transaction.
+func (db *DB) Begin() *Tx {
+	db.nextTxnID++
+	return &Tx{db: db, id: db.nextTxnID}
+}
+
+func (tx *Tx) Set(key, val []byte) {
+	tx.ops = append(tx.ops, pendingOp{op: WAL_OP_SET, key: key, val: val})
+}
+
+func (tx *Tx) Del(key []byte) {
+	tx.ops = append(tx.ops, pendingOp{op: WAL_OP_DEL, key: key})
+}
+
+// Rollback discards every buffered mutation; nothing was ever logged or
+// applied, so there is nothing else to undo.
+func (tx *Tx) Rollback() {
+	tx.ops = nil
+	tx.done = true
+}
+
+// Commit durably logs the whole transaction as one fsynced write (every
+// op plus a trailing commit marker), then applies the ops to the
+// in-memory tree, then checkpoints once enough mutations have
+// accumulated since the last one.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("db: transaction already closed")
+	}
+	tx.done = true
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	commitLSN, err := tx.db.wal.AppendTxn(tx.id, tx.ops)
+	if err != nil {
+		return fmt.Errorf("db: commit: %w", err)
+	}
+
+	tree := tx.db.pager.Tree()
+	for _, o := range tx.ops {
+		switch o.op {
+		case WAL_OP_SET:
+			tree.Insert(o.key, o.val)
+		case WAL_OP_DEL:
+			tree.Delete(o.key)
+		}
+	}
+
+	tx.db.sinceCheckpoint += len(tx.ops)
+	if tx.db.sinceCheckpoint >= checkpointEvery {
+		return tx.db.checkpoint(commitLSN)
+	}
+	return nil
+}
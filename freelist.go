@@ -0,0 +1,98 @@
+package main
+
+import "encoding/binary"
+
+// FreeList is a persistent linked list of freed page numbers. Each list
+// node occupies one page and has the format:
+//
+// | next | count |  pointers  |
+// |  8B  |   2B  | count * 8B |
+//
+// The head of the list (a page number, 0 means empty) is kept in the
+// superblock so it survives restarts.
+const (
+	FREE_LIST_HEADER = 8 + 2
+	FREE_LIST_CAP    = (BTREE_PAGE_SIZE - FREE_LIST_HEADER) / 8
+)
+
+type LNode []byte // one page of the free list
+
+func (node LNode) getNext() uint64 {
+	return binary.LittleEndian.Uint64(node[0:8])
+}
+
+func (node LNode) setNext(next uint64) {
+	binary.LittleEndian.PutUint64(node[0:8], next)
+}
+
+func (node LNode) getCount() uint16 {
+	return binary.LittleEndian.Uint16(node[8:10])
+}
+
+func (node LNode) setCount(count uint16) {
+	binary.LittleEndian.PutUint16(node[8:10], count)
+}
+
+func (node LNode) getPtr(idx uint16) uint64 {
+	return binary.LittleEndian.Uint64(node[FREE_LIST_HEADER+8*idx:])
+}
+
+func (node LNode) setPtr(idx uint16, ptr uint64) {
+	binary.LittleEndian.PutUint64(node[FREE_LIST_HEADER+8*idx:], ptr)
+}
+
+// FreeList hands out previously-freed pages before the pager falls back to
+// growing the file, and records newly-freed pages for future reuse.
+type FreeList struct {
+	get func(uint64) []byte // read a page (free-list node or data page)
+	set func(uint64) []byte // obtain a page for an in-place update
+	new func([]byte) uint64 // append a brand-new page, growing the file
+
+	head uint64 // page number of the first free-list node, 0 if empty
+}
+
+// Pop returns a previously-freed page number and removes it from the list,
+// or 0 if the list is empty.
+func (fl *FreeList) Pop() uint64 {
+	if fl.head == 0 {
+		return 0
+	}
+	node := LNode(fl.get(fl.head))
+	count := node.getCount()
+	if count > 0 {
+		ptr := node.getPtr(count - 1)
+		top := LNode(fl.set(fl.head))
+		top.setCount(count - 1)
+		return ptr
+	}
+	// this node is drained, move on to the next one and free the node
+	// itself by reusing its page for the caller.
+	empty := fl.head
+	fl.head = node.getNext()
+	return empty
+}
+
+// Push adds a freed page number to the list, allocating a new list node
+// when the current head is full.
+func (fl *FreeList) Push(ptr uint64) {
+	if fl.head == 0 {
+		node := make([]byte, BTREE_PAGE_SIZE)
+		LNode(node).setNext(0)
+		LNode(node).setCount(0)
+		fl.head = fl.new(node)
+	}
+	node := LNode(fl.get(fl.head))
+	count := node.getCount()
+	if count < FREE_LIST_CAP {
+		top := LNode(fl.set(fl.head))
+		top.setPtr(count, ptr)
+		top.setCount(count + 1)
+		return
+	}
+	// current head is full, start a new one and chain it
+	next := make([]byte, BTREE_PAGE_SIZE)
+	LNode(next).setNext(fl.head)
+	LNode(next).setCount(1)
+	LNode(next).setPtr(0, ptr)
+	fl.head = fl.new(next)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestIteratorMultiLeafScan builds a tree spanning several leaves and
+// exercises SeekLE/Iterator the way a range scan would: seeking below the
+// smallest key (invalid), then walking every key forward via Next() and
+// back again via Prev().
+func TestIteratorMultiLeafScan(t *testing.T) {
+	tree, _ := newTestTree()
+
+	const n = 500
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%06d", i))
+		tree.Insert(keys[i], []byte(fmt.Sprintf("val-%06d", i)))
+	}
+
+	if it := tree.SeekLE([]byte("aaa-smaller-than-everything")); it.Valid() {
+		t.Fatalf("expected SeekLE below every key to be invalid")
+	}
+
+	it := tree.SeekLE(keys[0])
+	for i := 0; i < n; i++ {
+		if !it.Valid() {
+			t.Fatalf("forward scan stopped early at i=%d", i)
+		}
+		if !bytes.Equal(it.Key(), keys[i]) {
+			t.Fatalf("forward scan: i=%d got %q want %q", i, it.Key(), keys[i])
+		}
+		if want := fmt.Sprintf("val-%06d", i); string(it.Value()) != want {
+			t.Fatalf("forward scan: i=%d got val %q want %q", i, it.Value(), want)
+		}
+		it.Next()
+	}
+	if it.Valid() {
+		t.Fatalf("expected forward scan to be exhausted after the last key")
+	}
+
+	it = tree.SeekLE(keys[n-1])
+	for i := n - 1; i >= 0; i-- {
+		if !it.Valid() {
+			t.Fatalf("backward scan stopped early at i=%d", i)
+		}
+		if !bytes.Equal(it.Key(), keys[i]) {
+			t.Fatalf("backward scan: i=%d got %q want %q", i, it.Key(), keys[i])
+		}
+		it.Prev()
+	}
+	if it.Valid() {
+		t.Fatalf("expected backward scan to be exhausted before the first key")
+	}
+}
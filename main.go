@@ -13,6 +13,10 @@ const (
 	BTREE_MAX_VAL_SIZE = 3000
 )
 
+// values up to BTREE_MAX_OVERFLOW_VAL_SIZE are supported via the overflow
+// page chain in overflow.go; BTREE_MAX_VAL_SIZE only bounds what fits
+// inlined in a leaf slot.
+
 func init() {
 	node1max := HEADER + 8 + 2 + 4 + BTREE_MAX_KEY_SIZE
 	if !(node1max <= BTREE_PAGE_SIZE) { // maximum KV
@@ -13,15 +13,23 @@ const (
 
 // BNode format on disk
 //
-// | type | nkeys |  pointers  |   offsets  | key-values | unused |
+// | type | nkeys | pointers |   offsets  | key-values | unused |
 //
-// |  2B  |   2B  | nkeys * 8B | nkeys * 2B |     ...    |        |
+// |  2B  |   2B  | nkeys*8B | nkeys * 2B |     ...    |        |
 //
 // key-value format:
 //
 // | klen | vlen | key | val |
 //
 // |  2B  |  2B  | ... | ... |
+//
+// This is a B+ tree layout: internal nodes store only separator keys (vlen
+// is always 0) alongside nkeys child pointers, and leaf nodes store keys
+// with values. Range scans walk leaves via a root-to-leaf cursor (see
+// Iterator in tree.go) rather than a physical sibling pointer: every
+// mutation is copy-on-write, so a leaf's page number changes whenever it
+// is touched, and a stored sibling pointer in some other, untouched leaf
+// would have no way to learn about the move.
 type BNode []byte // can be dumped to the disk
 
 func (node BNode) btype() uint16 {
@@ -39,7 +47,7 @@ func (node BNode) setHeader(btype uint16, nkeys uint16) {
 	binary.LittleEndian.PutUint16(node[2:4], nkeys)
 }
 
-// pointers
+// pointers. There are nkeys slots.
 func (node BNode) getPtr(idx uint16) uint64 {
 	if !(idx < node.nkeys()) {
 		log.Fatal("fatal error")
@@ -93,58 +101,157 @@ func (node BNode) getKey(idx uint16) []byte {
 	return node[pos+4:][:klen]
 }
 
+// getVal returns the raw bytes stored for idx: either the real value, or
+// (if the value was too large to inline) the small overflow stub written
+// in its place. Use (*BTree).getValue to transparently resolve a stub.
 func (node BNode) getVal(idx uint16) []byte {
 	if !(idx < node.nkeys()) {
 		log.Fatal("invalid node index")
 	}
 	pos := node.kvPos(idx)
 	klen := binary.LittleEndian.Uint16(node[pos:])
-	vlen := binary.LittleEndian.Uint16(node[pos+2:])
+	vlen := node.getStoredVlen(idx)
+	if vlen == valStubSentinel {
+		return node[pos+4+klen:][:valStubSize]
+	}
 	return node[pos+4+klen:][:vlen]
 }
 
+// getStoredVlen returns the raw vlen header field, which is either a real
+// length or the valStubSentinel marker for an overflowed value.
+func (node BNode) getStoredVlen(idx uint16) uint16 {
+	pos := node.kvPos(idx)
+	return binary.LittleEndian.Uint16(node[pos+2:])
+}
+
 // node size in bytes
 func (node BNode) nbytes() uint16 {
 	return node.kvPos(node.nkeys())
 }
 
 // returns the first kid node whose range intersects the key. (kid[i] <= key)
-// TODO: binary search
+//
+// Binary search over the offset array: sort.Search-style bisection on
+// [0, nkeys), using keyCompare (an 8-byte prefix compare with a
+// bytes.Compare fallback) to keep the hot loop branch-light.
+//
+// The result is a floor search: it returns nkeys()-1 (wrapping to 65535,
+// i.e. "no floor") when key is smaller than every key in node. Callers
+// that use the result to descend into a child (rather than to locate an
+// existing leaf slot) must treat that wraparound as kid 0.
 func nodeLookupLE(node BNode, key []byte) uint16 {
 	nkeys := node.nkeys()
-	var i uint16
-	for i = 0; i < nkeys; i++ {
-		cmp := bytes.Compare(node.getKey(i), key)
-		if cmp == 0 {
-			return i
+	lo, hi := 0, int(nkeys) // search [lo, hi)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if keyCompare(node.getKey(uint16(mid)), key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
 		}
-		if cmp > 0 {
-			return i - 1
+	}
+	return uint16(lo - 1)
+}
+
+// keyCompare orders a and b like bytes.Compare, but first compares the
+// leading 8 bytes of each as a big-endian uint64 so most keys are decided
+// without walking the full byte slices.
+func keyCompare(a, b []byte) int {
+	an, bn := len(a), len(b)
+	n := an
+	if bn < n {
+		n = bn
+	}
+	if n >= 8 {
+		au := binary.BigEndian.Uint64(a[:8])
+		bu := binary.BigEndian.Uint64(b[:8])
+		if au != bu {
+			if au < bu {
+				return -1
+			}
+			return 1
 		}
 	}
-	return i - 1
+	return bytes.Compare(a, b)
 }
 
 // add a new key to a leaf node
 func leafInsert(
-	new BNode, old BNode, idx uint16, key []byte, val []byte,
+	tree *BTree, new BNode, old BNode, idx uint16, key []byte, val []byte,
 ) {
 	new.setHeader(BNODE_LEAF, old.nkeys()+1)
 	nodeAppendRange(new, old, 0, 0, idx)                   // copy the keys before idx
-	nodeAppendKV(new, idx, 0, key, val)                    // the new key
+	appendKV(tree, new, idx, 0, key, val)                  // the new key
 	nodeAppendRange(new, old, idx+1, idx, old.nkeys()-idx) // keys from idx
 }
 
 // update a leaf node
-func leafUpdate(new BNode, old BNode, idx uint16, key []byte, val []byte) {
+func leafUpdate(tree *BTree, new BNode, old BNode, idx uint16, key []byte, val []byte) {
 	new.setHeader(BNODE_LEAF, old.nkeys())
 	nodeAppendRange(new, old, 0, 0, idx)
-	nodeAppendKV(new, idx, 0, key, val)
+	freeOverflowIfAny(tree, old, idx) // the old value is about to be overwritten
+	appendKV(tree, new, idx, 0, key, val)
 	nodeAppendRange(new, old, idx+1, idx+1, old.nkeys()-(idx+1))
 }
 
-// copy a KV into the position
+// remove a key from a leaf node
+func leafDelete(tree *BTree, new BNode, old BNode, idx uint16) {
+	new.setHeader(BNODE_LEAF, old.nkeys()-1)
+	nodeAppendRange(new, old, 0, 0, idx)
+	freeOverflowIfAny(tree, old, idx)
+	nodeAppendRange(new, old, idx, idx+1, old.nkeys()-(idx+1))
+}
+
+// merge two nodes of the same type into one
+func nodeMerge(new BNode, left BNode, right BNode) {
+	new.setHeader(left.btype(), left.nkeys()+right.nkeys())
+	nodeAppendRange(new, left, 0, 0, left.nkeys())
+	nodeAppendRange(new, right, left.nkeys(), 0, right.nkeys())
+}
+
+// nodeBorrowLeft moves left's last KV to the front of right, used to
+// rebalance an underfull right sibling when it's too small to stand on
+// its own but merging with left wouldn't fit in one page.
+func nodeBorrowLeft(newLeft BNode, newRight BNode, left BNode, right BNode) {
+	last := left.nkeys() - 1
+	newLeft.setHeader(left.btype(), last)
+	nodeAppendRange(newLeft, left, 0, 0, last)
+
+	newRight.setHeader(right.btype(), right.nkeys()+1)
+	nodeAppendKVRaw(newRight, 0, left.getPtr(last), left.getKey(last), left.getStoredVlen(last), left.getVal(last))
+	nodeAppendRange(newRight, right, 1, 0, right.nkeys())
+}
+
+// nodeBorrowRight moves right's first KV to the end of left, the mirror
+// image of nodeBorrowLeft for an underfull left sibling.
+func nodeBorrowRight(newLeft BNode, newRight BNode, left BNode, right BNode) {
+	n := left.nkeys()
+	newLeft.setHeader(left.btype(), n+1)
+	nodeAppendRange(newLeft, left, 0, 0, n)
+	nodeAppendKVRaw(newLeft, n, right.getPtr(0), right.getKey(0), right.getStoredVlen(0), right.getVal(0))
+
+	newRight.setHeader(right.btype(), right.nkeys()-1)
+	nodeAppendRange(newRight, right, 0, 1, right.nkeys()-1)
+}
+
+// replace 2 adjacent kids (idx and idx+1) with 1
+func nodeReplace2Kid(new BNode, old BNode, idx uint16, ptr uint64, key []byte) {
+	new.setHeader(BNODE_NODE, old.nkeys()-1)
+	nodeAppendRange(new, old, 0, 0, idx)
+	nodeAppendKV(new, idx, ptr, key, nil)
+	nodeAppendRange(new, old, idx+1, idx+2, old.nkeys()-(idx+2))
+}
+
+// copy a KV into the position, inferring vlen from len(val)
 func nodeAppendKV(new BNode, idx uint16, ptr uint64, key []byte, val []byte) {
+	nodeAppendKVRaw(new, idx, ptr, key, uint16(len(val)), val)
+}
+
+// nodeAppendKVRaw is like nodeAppendKV but takes an explicit vlen header
+// field separate from len(val), so a slot can be propagated verbatim even
+// when its vlen is the overflow sentinel (val is then the small stub, not
+// the logical value length).
+func nodeAppendKVRaw(new BNode, idx uint16, ptr uint64, key []byte, vlen uint16, val []byte) {
 	// pointers
 	new.setPtr(idx, ptr)
 	// KVs
@@ -152,20 +259,21 @@ func nodeAppendKV(new BNode, idx uint16, ptr uint64, key []byte, val []byte) {
 	// append to the node the len of key
 	binary.LittleEndian.PutUint16(new[pos:], uint16(len(key)))
 	// append to the node the len of val
-	binary.LittleEndian.PutUint16(new[pos+2:], uint16(len(val)))
+	binary.LittleEndian.PutUint16(new[pos+2:], vlen)
 	// copy the key
 	copy(new[pos+4:], key)
 	// copy the value
 	copy(new[pos+4+uint16(len(key)):], val)
 	// the offset of the next key
-	new.setOffset(idx+1, new.getOffset(idx)+4+uint16((len(key)+len(val))))
+	new.setOffset(idx+1, new.getOffset(idx)+4+uint16(len(key))+uint16(len(val)))
 }
 
-// copy multiple KVs and pointers
+// copy multiple KVs and pointers, preserving each slot's raw vlen (and
+// hence an overflow stub's sentinel) rather than recomputing it
 func nodeAppendRange(new BNode, old BNode, dstNew uint16, srcOld uint16, n uint16) {
 	for i := uint16(0); i < n; i++ {
 		dst, src := dstNew+i, srcOld+i
-		nodeAppendKV(new, dst, old.getPtr(src), old.getKey(src), old.getVal(src))
+		nodeAppendKVRaw(new, dst, old.getPtr(src), old.getKey(src), old.getStoredVlen(src), old.getVal(src))
 	}
 }
 
@@ -219,6 +327,7 @@ func nodeSplit3(old BNode) (uint16, [3]BNode) {
 	left := BNode(make([]byte, 2*BTREE_PAGE_SIZE)) // might be split later
 	right := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
 	nodeSplit2(left, right, old)
+	right = right[:BTREE_PAGE_SIZE]
 	if left.nbytes() <= BTREE_PAGE_SIZE {
 		left = left[:BTREE_PAGE_SIZE]
 		return 2, [3]BNode{left, right} // 2 nodes
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// buildLookupBenchNode returns a leaf node holding n sorted 8-byte keys
+// (as every leaf's keys are, regardless of workload) plus those keys in
+// order, for benchmarks to look up in whatever pattern they choose.
+func buildLookupBenchNode(n int) (BNode, [][]byte) {
+	node := BNode(make([]byte, BTREE_PAGE_SIZE))
+	node.setHeader(BNODE_LEAF, uint16(n))
+	keys := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		keys[i] = key
+		nodeAppendKV(node, uint16(i), 0, key, []byte("value"))
+	}
+	return node, keys
+}
+
+// BenchmarkNodeLookupLEMonotonic looks up keys in increasing order, the
+// pattern a range scan or bulk sequential load produces.
+func BenchmarkNodeLookupLEMonotonic(b *testing.B) {
+	node, keys := buildLookupBenchNode(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nodeLookupLE(node, keys[i%len(keys)])
+	}
+}
+
+// BenchmarkNodeLookupLERandom looks up the same keys in random order, the
+// pattern point queries under a random workload produce.
+func BenchmarkNodeLookupLERandom(b *testing.B) {
+	node, keys := buildLookupBenchNode(100)
+	order := rand.Perm(len(keys))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nodeLookupLE(node, keys[order[i%len(order)]])
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import "encoding/binary"
+
+// Values larger than BTREE_MAX_VAL_SIZE don't fit in a leaf slot, so they
+// are stored out-of-line in a chain of BNODE_OVERFLOW pages:
+//
+// | type | next |  data  |
+// |  2B  |  8B  |  ...   |
+//
+// The leaf slot itself holds a small stub in place of the value:
+//
+// | overflow_ptr | total_len |
+// |      8B      |     4B    |
+//
+// marked by a vlen header field of valStubSentinel so getVal/appendKV
+// know to treat it as a stub rather than a literal value.
+const (
+	BNODE_OVERFLOW              = 3
+	BTREE_MAX_OVERFLOW_VAL_SIZE = 8 << 20 // 8MiB
+
+	valStubSentinel = 0xFFFF
+	valStubSize     = 8 + 4 // overflow_ptr + total_len
+
+	overflowHeader = 2 + 8
+	overflowChunk  = BTREE_PAGE_SIZE - overflowHeader
+)
+
+// appendKV stores key/val at idx, spilling val into an overflow page
+// chain (and writing a stub in its place) when it's too large to inline.
+func appendKV(tree *BTree, new BNode, idx uint16, ptr uint64, key []byte, val []byte) {
+	if len(val) <= BTREE_MAX_VAL_SIZE {
+		nodeAppendKV(new, idx, ptr, key, val)
+		return
+	}
+	if len(val) > BTREE_MAX_OVERFLOW_VAL_SIZE {
+		panic("overflow: value exceeds BTREE_MAX_OVERFLOW_VAL_SIZE")
+	}
+	head := writeOverflow(tree.new, val)
+	stub := make([]byte, valStubSize)
+	binary.LittleEndian.PutUint64(stub[0:8], head)
+	binary.LittleEndian.PutUint32(stub[8:12], uint32(len(val)))
+	nodeAppendKVRaw(new, idx, ptr, key, valStubSentinel, stub)
+}
+
+// freeOverflowIfAny releases the overflow chain backing idx's value, if
+// it has one. Called before a slot's value is overwritten or removed.
+func freeOverflowIfAny(tree *BTree, node BNode, idx uint16) {
+	if node.getStoredVlen(idx) != valStubSentinel {
+		return
+	}
+	stub := node.getVal(idx)
+	freeOverflow(tree.get, tree.del, binary.LittleEndian.Uint64(stub[0:8]))
+}
+
+// getValue returns the logical value at idx, transparently reassembling
+// it from the overflow chain if it was too large to inline.
+func (tree *BTree) getValue(node BNode, idx uint16) []byte {
+	if node.getStoredVlen(idx) != valStubSentinel {
+		return node.getVal(idx)
+	}
+	stub := node.getVal(idx)
+	head := binary.LittleEndian.Uint64(stub[0:8])
+	total := binary.LittleEndian.Uint32(stub[8:12])
+	return readOverflow(tree.get, head, total)
+}
+
+// writeOverflow splits val into a chain of overflow pages, allocating
+// tail-first so each page's "next" pointer is known before it is written,
+// and returns the page number of the first (head) page.
+func writeOverflow(newPage func([]byte) uint64, val []byte) uint64 {
+	nchunks := (len(val) + overflowChunk - 1) / overflowChunk
+	var next uint64
+	for i := nchunks - 1; i >= 0; i-- {
+		off := i * overflowChunk
+		end := off + overflowChunk
+		if end > len(val) {
+			end = len(val)
+		}
+		page := make([]byte, BTREE_PAGE_SIZE)
+		binary.LittleEndian.PutUint16(page[0:2], BNODE_OVERFLOW)
+		binary.LittleEndian.PutUint64(page[2:10], next)
+		copy(page[overflowHeader:], val[off:end])
+		next = newPage(page)
+	}
+	return next
+}
+
+// readOverflow walks the chain starting at head and reassembles the
+// original value (total bytes long).
+func readOverflow(getPage func(uint64) []byte, head uint64, total uint32) []byte {
+	out := make([]byte, 0, total)
+	for ptr := head; ptr != 0 && uint32(len(out)) < total; {
+		page := getPage(ptr)
+		chunk := total - uint32(len(out))
+		if chunk > overflowChunk {
+			chunk = overflowChunk
+		}
+		out = append(out, page[overflowHeader:overflowHeader+chunk]...)
+		ptr = binary.LittleEndian.Uint64(page[2:10])
+	}
+	return out
+}
+
+// freeOverflow releases every page in the chain starting at head.
+func freeOverflow(getPage func(uint64) []byte, delPage func(uint64), head uint64) {
+	for ptr := head; ptr != 0; {
+		next := binary.LittleEndian.Uint64(getPage(ptr)[2:10])
+		delPage(ptr)
+		ptr = next
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestOverflowRoundTripsLargeValue inserts a 1MB value (well past
+// BTREE_MAX_VAL_SIZE, so it must spill into the overflow-page chain),
+// reads it back through the tree, and checks it survives byte-for-byte,
+// then deletes it and checks every overflow page was freed.
+func TestOverflowRoundTripsLargeValue(t *testing.T) {
+	tree, pages := newTestTree()
+
+	key := []byte("big-value")
+	val := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(val)
+
+	tree.Insert(key, val)
+
+	root := BNode(tree.get(tree.root))
+	idx := nodeLookupLE(root, key)
+	got := tree.getValue(root, idx)
+	if !bytes.Equal(got, val) {
+		t.Fatalf("overflowed value didn't round-trip: got %d bytes, want %d", len(got), len(val))
+	}
+
+	before := len(pages.pages)
+	if !tree.Delete(key) {
+		t.Fatalf("delete of %s reported not found", key)
+	}
+	if tree.root != 0 {
+		t.Fatalf("expected an empty tree after deleting the only key, root = %d", tree.root)
+	}
+	if len(pages.pages) != 0 {
+		t.Fatalf("expected every overflow page (of %d pages) freed, %d still allocated", before, len(pages.pages))
+	}
+}
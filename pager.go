@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"syscall"
+)
+
+// The pager backs a BTree with an mmap'd file split into BTREE_PAGE_SIZE
+// pages. Page 0 holds two copies ("slots") of the superblock so a torn
+// write (a crash mid-write) always leaves at least one intact copy:
+//
+// | sig | root | free_list_head | next_page | checkpoint_lsn | seq | crc32 |
+// | 16B |  8B  |       8B       |    8B     |       8B       | 8B  |  4B   |
+//
+// Writes alternate slots and bump seq; on load, whichever valid (sig +
+// crc match) slot has the higher seq wins.
+const (
+	DB_SIG         = "byo-database-05"
+	META_PAGE      = 0
+	META_SLOT_SIZE = 64
+	META_SLOTS     = 2
+	metaBodyLen    = 16 + 8 + 8 + 8 + 8 + 8 // everything covered by the crc
+)
+
+// pagerReserve is the virtual address space mmapGrow reserves the first
+// time it runs, far beyond what any realistic file needs. Reserving it up
+// front means ordinary page allocation (appendPage, invoked from deep
+// inside a recursive BTree.Insert/Delete) almost never has to remap:
+// munmap+mmap is not guaranteed to return the same address, so a remap
+// mid-operation would silently invalidate a BNode slice an ancestor stack
+// frame is still holding from before the remap.
+const pagerReserve = 1 << 30 // 1GiB
+
+// Pager is the mmap-backed page store. It owns the file and the free-list,
+// and wires the BTree.get/.new/.del callbacks to them.
+type Pager struct {
+	path string
+	fd   *os.File
+	buf  []byte // the whole file, mmap'd
+
+	tree BTree
+	free FreeList
+
+	nextPage      uint64 // one past the highest page ever handed out
+	checkpointLSN uint64 // highest WAL lsn folded into the superblock
+	metaSeq       uint64 // monotonic sequence number of the last write
+	metaSlot      int    // which slot (0 or 1) holds metaSeq
+}
+
+func OpenPager(path string) (*Pager, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("pager: open %s: %w", path, err)
+	}
+
+	p := &Pager{path: path, fd: fd, nextPage: 1, metaSlot: -1}
+	if err := p.mmapGrow(META_PAGE + 1); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if err := p.loadMeta(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	p.tree.get = p.Get
+	p.tree.new = p.New
+	p.tree.del = p.Del
+
+	p.free.get = p.Get
+	p.free.set = p.set
+	p.free.new = p.appendPage
+
+	return p, nil
+}
+
+func (p *Pager) Tree() *BTree {
+	return &p.tree
+}
+
+func (p *Pager) CheckpointLSN() uint64 {
+	return p.checkpointLSN
+}
+
+// Get dereferences a page number into its bytes within the mmap.
+func (p *Pager) Get(ptr uint64) []byte {
+	start := ptr * BTREE_PAGE_SIZE
+	return p.buf[start : start+BTREE_PAGE_SIZE]
+}
+
+// set is like Get but documents intent: the caller is about to mutate the
+// page in place (used by the free-list, which never needs copy-on-write).
+func (p *Pager) set(ptr uint64) []byte {
+	return p.Get(ptr)
+}
+
+// New allocates a page for data, preferring a page from the free-list
+// before growing the file, and returns its page number.
+func (p *Pager) New(data []byte) uint64 {
+	if len(data) > BTREE_PAGE_SIZE {
+		panic("pager: page too large")
+	}
+	ptr := p.free.Pop()
+	if ptr == 0 {
+		return p.appendPage(data)
+	}
+	copy(p.Get(ptr), data)
+	return ptr
+}
+
+// appendPage grows the file by one page and writes data into it, without
+// consulting the free-list (used directly by the free-list itself to avoid
+// recursion).
+func (p *Pager) appendPage(data []byte) uint64 {
+	ptr := p.nextPage
+	p.nextPage++
+	if err := p.mmapGrow(p.nextPage); err != nil {
+		panic(err) // the page-oriented API has no room for an error return
+	}
+	copy(p.Get(ptr), data)
+	return ptr
+}
+
+// Del returns a page to the free-list for future reuse.
+func (p *Pager) Del(ptr uint64) {
+	p.free.Push(ptr)
+}
+
+// mmapGrow ensures the mapping can address at least npages pages. The
+// first call reserves pagerReserve bytes up front (doubling further if
+// npages somehow exceeds even that), so in the overwhelmingly common case
+// the mapping already covers npages and this is a no-op: no truncate, no
+// remap, and in particular no risk of invalidating a BNode slice still
+// held by an in-flight recursive BTree call. Growing the reservation
+// (rare) never shrinks the file, even across reopening one that already
+// outgrew pagerReserve in an earlier session.
+func (p *Pager) mmapGrow(npages uint64) error {
+	size := npages * BTREE_PAGE_SIZE
+	mapSize := uint64(len(p.buf))
+	if mapSize == 0 {
+		mapSize = pagerReserve
+	}
+	for mapSize < size {
+		mapSize *= 2
+	}
+	if mapSize <= uint64(len(p.buf)) {
+		return nil
+	}
+
+	info, err := p.fd.Stat()
+	if err != nil {
+		return fmt.Errorf("pager: stat: %w", err)
+	}
+	if uint64(info.Size()) > mapSize {
+		mapSize = uint64(info.Size())
+	}
+
+	if err := p.fd.Truncate(int64(mapSize)); err != nil {
+		return fmt.Errorf("pager: truncate: %w", err)
+	}
+	if p.buf != nil {
+		if err := syscall.Munmap(p.buf); err != nil {
+			return fmt.Errorf("pager: munmap: %w", err)
+		}
+	}
+	buf, err := syscall.Mmap(int(p.fd.Fd()), 0, int(mapSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("pager: mmap: %w", err)
+	}
+	p.buf = buf
+	return nil
+}
+
+func metaSlotBytes(page []byte, slot int) []byte {
+	off := slot * META_SLOT_SIZE
+	return page[off : off+META_SLOT_SIZE]
+}
+
+// readMetaSlot validates a slot's signature and crc32, returning ok=false
+// for an empty or torn slot.
+func readMetaSlot(b []byte) (ok bool, root, freeHead, nextPage, checkpointLSN, seq uint64) {
+	if string(b[0:len(DB_SIG)]) != DB_SIG {
+		return false, 0, 0, 0, 0, 0
+	}
+	if crc32.ChecksumIEEE(b[:metaBodyLen]) != binary.LittleEndian.Uint32(b[metaBodyLen:metaBodyLen+4]) {
+		return false, 0, 0, 0, 0, 0
+	}
+	root = binary.LittleEndian.Uint64(b[16:24])
+	freeHead = binary.LittleEndian.Uint64(b[24:32])
+	nextPage = binary.LittleEndian.Uint64(b[32:40])
+	checkpointLSN = binary.LittleEndian.Uint64(b[40:48])
+	seq = binary.LittleEndian.Uint64(b[48:56])
+	return true, root, freeHead, nextPage, checkpointLSN, seq
+}
+
+func writeMetaSlot(b []byte, root, freeHead, nextPage, checkpointLSN, seq uint64) {
+	copy(b[0:16], DB_SIG)
+	binary.LittleEndian.PutUint64(b[16:24], root)
+	binary.LittleEndian.PutUint64(b[24:32], freeHead)
+	binary.LittleEndian.PutUint64(b[32:40], nextPage)
+	binary.LittleEndian.PutUint64(b[40:48], checkpointLSN)
+	binary.LittleEndian.PutUint64(b[48:56], seq)
+	binary.LittleEndian.PutUint32(b[metaBodyLen:metaBodyLen+4], crc32.ChecksumIEEE(b[:metaBodyLen]))
+}
+
+// loadMeta picks the valid slot with the higher sequence number, or
+// initializes a brand-new superblock if neither slot is valid.
+func (p *Pager) loadMeta() error {
+	page := p.buf[:META_SLOTS*META_SLOT_SIZE]
+	okA, rootA, freeA, nextA, ckA, seqA := readMetaSlot(metaSlotBytes(page, 0))
+	okB, rootB, freeB, nextB, ckB, seqB := readMetaSlot(metaSlotBytes(page, 1))
+
+	switch {
+	case okA && (!okB || seqA >= seqB):
+		p.tree.root, p.free.head, p.nextPage, p.checkpointLSN, p.metaSeq, p.metaSlot = rootA, freeA, nextA, ckA, seqA, 0
+	case okB:
+		p.tree.root, p.free.head, p.nextPage, p.checkpointLSN, p.metaSeq, p.metaSlot = rootB, freeB, nextB, ckB, seqB, 1
+	default:
+		// brand-new file
+		p.tree.root, p.free.head, p.nextPage, p.checkpointLSN, p.metaSeq = 0, 0, 1, 0, 0
+	}
+	return p.saveMeta()
+}
+
+// saveMeta publishes the current root pointer, free-list head and
+// checkpoint lsn to the other slot (double buffering: the previous slot
+// is left untouched until this write completes).
+func (p *Pager) saveMeta() error {
+	p.metaSeq++
+	p.metaSlot = (p.metaSlot + 1) % META_SLOTS
+	slot := metaSlotBytes(p.buf[:META_SLOTS*META_SLOT_SIZE], p.metaSlot)
+	writeMetaSlot(slot, p.tree.root, p.free.head, p.nextPage, p.checkpointLSN, p.metaSeq)
+	return nil
+}
+
+// Flush publishes the superblock and fsyncs the file. fsync on the
+// descriptor also flushes dirty MAP_SHARED pages to disk, so there is no
+// separate msync step.
+func (p *Pager) Flush() error {
+	if err := p.saveMeta(); err != nil {
+		return err
+	}
+	return p.fd.Sync()
+}
+
+func (p *Pager) Close() error {
+	if p.buf != nil {
+		_ = syscall.Munmap(p.buf)
+		p.buf = nil
+	}
+	return p.fd.Close()
+}
@@ -11,21 +11,61 @@ type BTree struct {
 	del func(uint64)        // deallocate a page number
 }
 
+// Insert adds or updates a key, creating the very first root leaf if the
+// tree is empty and splitting (and replacing) the root when it outgrows
+// one page.
+func (tree *BTree) Insert(key []byte, val []byte) {
+	if tree.root == 0 {
+		root := BNode(make([]byte, BTREE_PAGE_SIZE))
+		root.setHeader(BNODE_LEAF, 1)
+		appendKV(tree, root, 0, 0, key, val)
+		tree.root = tree.new(root)
+		return
+	}
+
+	node := treeInsert(tree, BNode(tree.get(tree.root)), key, val)
+	oldRoot := tree.root
+	nsplit, split := nodeSplit3(node)
+	tree.del(oldRoot)
+	if nsplit == 1 {
+		tree.root = tree.new(split[0])
+		return
+	}
+	// the root outgrew one page: build a fresh internal root over the
+	// split pieces.
+	kids := split[:nsplit]
+	root := BNode(make([]byte, BTREE_PAGE_SIZE))
+	root.setHeader(BNODE_NODE, nsplit)
+	for i, kid := range kids {
+		nodeAppendKV(root, uint16(i), tree.new(kid), kid.getKey(0), nil)
+	}
+	tree.root = tree.new(root)
+}
+
 func treeInsert(tree *BTree, node BNode, key []byte, val []byte) BNode {
 	// the extra size allows it to exceed 1 page temporarily.
 	new := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
 	// where to insert the key?
-	idx := nodeLookupLE(new, key)
+	idx := nodeLookupLE(node, key)
 	switch node.btype() {
 	case BNODE_LEAF:
-		if bytes.Equal(key, node.getKey(idx)) {
-			leafUpdate(new, node, idx, key, val)
+		if idx < node.nkeys() && bytes.Equal(key, node.getKey(idx)) {
+			leafUpdate(tree, new, node, idx, key, val)
 		} else {
-			leafInsert(node, node, idx, key, val)
+			// idx is the floor (largest key <= target); a genuinely new
+			// key is inserted right after it (idx+1 wraps to 0 when the
+			// key is smaller than everything in the node).
+			leafInsert(tree, new, node, idx+1, key, val)
 		}
 	case BNODE_NODE: // internal node, walk into the child node
+		if idx >= node.nkeys() {
+			// key is smaller than every separator in this node (it
+			// underflows nodeLookupLE's floor search); kid 0 still covers
+			// it, since separators only bound kids 1..n from below.
+			idx = 0
+		}
 		kptr := node.getPtr(idx)
-		knode := treeInsert(tree, node, key, val)
+		knode := treeInsert(tree, BNode(tree.get(kptr)), key, val)
 		// after insertion, split the result
 		nsplit, split := nodeSplit3(knode)
 		// deallocate the old kid node
@@ -42,8 +82,290 @@ func nodeReplaceKidN(tree *BTree, new BNode, old BNode, idx uint16, kids ...BNod
 	inc := uint16(len(kids))
 	new.setHeader(BNODE_NODE, old.nkeys()+inc-1)
 	nodeAppendRange(new, old, 0, 0, idx)
-	for i, node := range kids {
-		nodeAppendKV(new, idx+uint16(i), tree.new(node), node.getKey(0), nil)
+	for i, kid := range kids {
+		nodeAppendKV(new, idx+uint16(i), tree.new(kid), kid.getKey(0), nil)
 	}
 	nodeAppendRange(new, old, idx+inc, idx+1, old.nkeys()-(idx+1))
 }
+
+// treeDelete removes key from the subtree rooted at node, returning the
+// updated node (copy-on-write), or a nil-length BNode if key was not
+// found.
+func treeDelete(tree *BTree, node BNode, key []byte) BNode {
+	idx := nodeLookupLE(node, key)
+	switch node.btype() {
+	case BNODE_LEAF:
+		if idx >= node.nkeys() || !bytes.Equal(key, node.getKey(idx)) {
+			return BNode{} // not found
+		}
+		new := BNode(make([]byte, BTREE_PAGE_SIZE))
+		leafDelete(tree, new, node, idx)
+		return new
+	case BNODE_NODE:
+		if idx >= node.nkeys() {
+			// key is smaller than every separator in this node; kid 0
+			// still covers it (see treeInsert's BNODE_NODE case).
+			idx = 0
+		}
+		return nodeDelete(tree, node, idx, key)
+	default:
+		panic("bad node!")
+	}
+}
+
+// nodeDelete deletes key from the idx'th kid of node and, if the kid
+// shrank below BTREE_PAGE_SIZE/4, merges it with a sibling, or — if it
+// can't fit with either sibling in one page — borrows a single KV from
+// one instead (or just updates it in place if it's still large enough on
+// its own).
+func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
+	kptr := node.getPtr(idx)
+	updated := treeDelete(tree, BNode(tree.get(kptr)), key)
+	if len(updated) == 0 {
+		return BNode{} // not found, nothing changed
+	}
+	tree.del(kptr)
+
+	new := BNode(make([]byte, BTREE_PAGE_SIZE))
+	mergeDir, sibling := shouldMerge(tree, node, idx, updated)
+	switch {
+	case mergeDir < 0: // merge with the left sibling
+		merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+		nodeMerge(merged, sibling, updated)
+		tree.del(node.getPtr(idx - 1))
+		nodeReplace2Kid(new, node, idx-1, tree.new(merged), merged.getKey(0))
+	case mergeDir > 0: // merge with the right sibling
+		merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+		nodeMerge(merged, updated, sibling)
+		tree.del(node.getPtr(idx + 1))
+		nodeReplace2Kid(new, node, idx, tree.new(merged), merged.getKey(0))
+	case updated.nkeys() == 0:
+		// no sibling to merge with: only possible when the deleted kid
+		// was node's only child. Collapse to empty and let the caller
+		// (nodeDelete one level up, or BTree.Delete at the root) propagate.
+		new.setHeader(BNODE_NODE, 0)
+	default:
+		// updated didn't shrink enough to merge with either sibling (or
+		// has no sibling to merge with) but may still be underfull:
+		// borrow one KV from whichever sibling can spare it instead of
+		// leaving it underfull.
+		if borrowed := tryBorrow(tree, node, idx, updated); len(borrowed) != 0 {
+			return borrowed
+		}
+		nodeReplaceKidN(tree, new, node, idx, updated)
+	}
+	return new
+}
+
+// tryBorrow rebalances an underfull kid (updated, the idx'th kid of node)
+// by moving one KV from a sibling that has more than one key, when
+// merging with neither sibling fits in a page. The left sibling is tried
+// first. It returns a nil-length BNode if updated isn't underfull or
+// neither sibling can spare a key, leaving the caller to update the kid
+// in place.
+func tryBorrow(tree *BTree, node BNode, idx uint16, updated BNode) BNode {
+	if updated.nbytes() > BTREE_PAGE_SIZE/4 {
+		return BNode{}
+	}
+	if idx > 0 {
+		left := BNode(tree.get(node.getPtr(idx - 1)))
+		if left.nkeys() > 1 {
+			newLeft := BNode(make([]byte, BTREE_PAGE_SIZE))
+			newRight := BNode(make([]byte, BTREE_PAGE_SIZE))
+			nodeBorrowLeft(newLeft, newRight, left, updated)
+			tree.del(node.getPtr(idx - 1))
+			new := BNode(make([]byte, BTREE_PAGE_SIZE))
+			nodeReplace2Kids(tree, new, node, idx-1, newLeft, newRight)
+			return new
+		}
+	}
+	if idx+1 < node.nkeys() {
+		right := BNode(tree.get(node.getPtr(idx + 1)))
+		if right.nkeys() > 1 {
+			newLeft := BNode(make([]byte, BTREE_PAGE_SIZE))
+			newRight := BNode(make([]byte, BTREE_PAGE_SIZE))
+			nodeBorrowRight(newLeft, newRight, updated, right)
+			tree.del(node.getPtr(idx + 1))
+			new := BNode(make([]byte, BTREE_PAGE_SIZE))
+			nodeReplace2Kids(tree, new, node, idx, newLeft, newRight)
+			return new
+		}
+	}
+	return BNode{}
+}
+
+// nodeReplace2Kids replaces the 2 adjacent kids at idx and idx+1 with 2
+// new kids (used after borrowing a KV between them), keeping old's other
+// kids and nkeys unchanged.
+func nodeReplace2Kids(tree *BTree, new BNode, old BNode, idx uint16, left BNode, right BNode) {
+	new.setHeader(BNODE_NODE, old.nkeys())
+	nodeAppendRange(new, old, 0, 0, idx)
+	nodeAppendKV(new, idx, tree.new(left), left.getKey(0), nil)
+	nodeAppendKV(new, idx+1, tree.new(right), right.getKey(0), nil)
+	nodeAppendRange(new, old, idx+2, idx+2, old.nkeys()-(idx+2))
+}
+
+// shouldMerge reports whether updated (the idx'th kid, after a delete)
+// should be merged with a sibling, and if so, which one. It returns
+// mergeDir < 0 for the left sibling, > 0 for the right, 0 for neither
+// (the sibling doesn't exist, or merging wouldn't fit in one page — see
+// tryBorrow for the fallback rebalance in that case).
+func shouldMerge(tree *BTree, node BNode, idx uint16, updated BNode) (int, BNode) {
+	if updated.nbytes() > BTREE_PAGE_SIZE/4 {
+		return 0, BNode{}
+	}
+	if idx > 0 {
+		sibling := BNode(tree.get(node.getPtr(idx - 1)))
+		if sibling.nbytes()+updated.nbytes()-HEADER <= BTREE_PAGE_SIZE {
+			return -1, sibling
+		}
+	}
+	if idx+1 < node.nkeys() {
+		sibling := BNode(tree.get(node.getPtr(idx + 1)))
+		if sibling.nbytes()+updated.nbytes()-HEADER <= BTREE_PAGE_SIZE {
+			return +1, sibling
+		}
+	}
+	return 0, BNode{}
+}
+
+// Delete removes key from the tree, reporting whether it was present. If
+// the root collapses to a single child (or to nothing), tree.root is
+// updated accordingly.
+func (tree *BTree) Delete(key []byte) bool {
+	if tree.root == 0 {
+		return false
+	}
+	updated := treeDelete(tree, BNode(tree.get(tree.root)), key)
+	if len(updated) == 0 {
+		return false // not found
+	}
+	tree.del(tree.root)
+	switch {
+	case updated.btype() == BNODE_LEAF && updated.nkeys() == 0:
+		tree.root = 0 // the tree is now empty
+	case updated.btype() == BNODE_NODE && updated.nkeys() == 1:
+		tree.root = updated.getPtr(0) // collapse the root
+	default:
+		tree.root = tree.new(updated)
+	}
+	return true
+}
+
+// SeekLE returns an iterator positioned at the largest key <= key. The
+// iterator is invalid if the tree is empty or every key is greater than
+// key.
+func (tree *BTree) SeekLE(key []byte) *Iterator {
+	it := &Iterator{tree: tree}
+	if tree.root == 0 {
+		return it
+	}
+	ptr := tree.root
+	for {
+		node := BNode(tree.get(ptr))
+		idx := nodeLookupLE(node, key)
+		it.path = append(it.path, iterFrame{ptr, idx})
+		if node.btype() == BNODE_LEAF {
+			return it
+		}
+		if idx >= node.nkeys() {
+			// key is smaller than every separator in this node; kid 0
+			// still covers it (see treeInsert's BNODE_NODE case).
+			idx = 0
+			it.path[len(it.path)-1].idx = 0
+		}
+		ptr = node.getPtr(idx)
+	}
+}
+
+// iterFrame is one root-to-leaf step of an Iterator's descent: the page
+// visited and the key index chosen within it.
+type iterFrame struct {
+	ptr uint64
+	idx uint16
+}
+
+// Iterator walks a B+ tree's leaves in key order. It holds the root-to-leaf
+// path (rather than a physical next-leaf pointer) because every mutation
+// is copy-on-write: a leaf's page number changes whenever it's touched, so
+// a pointer stashed in some other, untouched leaf has no way to follow it.
+type Iterator struct {
+	tree *BTree
+	path []iterFrame // path[0] is the root, path[len-1] is the leaf
+}
+
+func (it *Iterator) Valid() bool {
+	if len(it.path) == 0 {
+		return false
+	}
+	leaf := it.path[len(it.path)-1]
+	return leaf.idx < BNode(it.tree.get(leaf.ptr)).nkeys()
+}
+
+func (it *Iterator) Key() []byte {
+	leaf := it.path[len(it.path)-1]
+	return BNode(it.tree.get(leaf.ptr)).getKey(leaf.idx)
+}
+
+func (it *Iterator) Value() []byte {
+	leaf := it.path[len(it.path)-1]
+	return it.tree.getValue(BNode(it.tree.get(leaf.ptr)), leaf.idx)
+}
+
+// Next advances to the next key, climbing back up the path to the nearest
+// ancestor with a right sibling and descending its leftmost children once
+// the current leaf is exhausted.
+func (it *Iterator) Next() {
+	last := len(it.path) - 1
+	leaf := BNode(it.tree.get(it.path[last].ptr))
+	if it.path[last].idx+1 < leaf.nkeys() {
+		it.path[last].idx++
+		return
+	}
+	for i := last - 1; i >= 0; i-- {
+		node := BNode(it.tree.get(it.path[i].ptr))
+		if it.path[i].idx+1 >= node.nkeys() {
+			continue
+		}
+		it.path[i].idx++
+		it.path = it.path[:i+1]
+		ptr := node.getPtr(it.path[i].idx)
+		for {
+			child := BNode(it.tree.get(ptr))
+			it.path = append(it.path, iterFrame{ptr, 0})
+			if child.btype() == BNODE_LEAF {
+				return
+			}
+			ptr = child.getPtr(0)
+		}
+	}
+	it.path = nil // exhausted the tree
+}
+
+// Prev moves to the previous key, the mirror image of Next.
+func (it *Iterator) Prev() {
+	last := len(it.path) - 1
+	if it.path[last].idx > 0 {
+		it.path[last].idx--
+		return
+	}
+	for i := last - 1; i >= 0; i-- {
+		if it.path[i].idx == 0 {
+			continue
+		}
+		it.path[i].idx--
+		it.path = it.path[:i+1]
+		node := BNode(it.tree.get(it.path[i].ptr))
+		ptr := node.getPtr(it.path[i].idx)
+		for {
+			child := BNode(it.tree.get(ptr))
+			rightmost := child.nkeys() - 1
+			it.path = append(it.path, iterFrame{ptr, rightmost})
+			if child.btype() == BNODE_LEAF {
+				return
+			}
+			ptr = child.getPtr(rightmost)
+		}
+	}
+	it.path = nil // stepped before the beginning
+}
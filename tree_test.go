@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// testPages is an in-memory page store standing in for a Pager, so BTree
+// can be exercised without a real file-backed mmap.
+type testPages struct {
+	pages map[uint64][]byte
+	next  uint64
+}
+
+func newTestPages() *testPages {
+	return &testPages{pages: map[uint64][]byte{}, next: 1}
+}
+
+func (p *testPages) get(ptr uint64) []byte { return p.pages[ptr] }
+
+func (p *testPages) new(data []byte) uint64 {
+	ptr := p.next
+	p.next++
+	cp := make([]byte, len(data))
+	copy(cp, data)
+	p.pages[ptr] = cp
+	return ptr
+}
+
+func (p *testPages) del(ptr uint64) { delete(p.pages, ptr) }
+
+func newTestTree() (*BTree, *testPages) {
+	pages := newTestPages()
+	return &BTree{get: pages.get, new: pages.new, del: pages.del}, pages
+}
+
+// TestDeleteRandomOrderFreesAllPages inserts a large number of keys,
+// deletes them back out in random order, and checks the tree collapses
+// to empty with every allocated page returned via tree.del.
+func TestDeleteRandomOrderFreesAllPages(t *testing.T) {
+	tree, pages := newTestTree()
+
+	const n = 2000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%06d", i))
+		tree.Insert(keys[i], []byte(fmt.Sprintf("val-%06d", i)))
+	}
+
+	for _, i := range rand.Perm(n) {
+		if !tree.Delete(keys[i]) {
+			t.Fatalf("delete of %s reported not found", keys[i])
+		}
+	}
+
+	if tree.root != 0 {
+		t.Fatalf("expected an empty tree, root = %d", tree.root)
+	}
+	if len(pages.pages) != 0 {
+		t.Fatalf("expected every page freed, %d still allocated", len(pages.pages))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// WAL op codes. WAL_OP_COMMIT carries no key/val; it marks the end of a
+// transaction so Replay can tell a fully-written txn from one truncated
+// mid-append by a crash.
+const (
+	WAL_OP_SET    = 1
+	WAL_OP_DEL    = 2
+	WAL_OP_COMMIT = 3
+)
+
+// WAL record framing:
+//
+// | reclen | lsn | txn_id | op | key_len | val_len | key | val | crc32 |
+// |   4B   | 8B  |   8B   | 1B |   4B    |   4B    | ... | ... |  4B   |
+//
+// reclen covers everything between itself and the crc32, so a reader can
+// tell a record is truncated before touching its payload.
+type walRecord struct {
+	lsn   uint64
+	txnID uint64
+	op    uint8
+	key   []byte
+	val   []byte
+}
+
+const walRecordHeader = 8 + 8 + 1 + 4 + 4
+
+func encodeWALRecord(r walRecord) []byte {
+	body := make([]byte, walRecordHeader+len(r.key)+len(r.val))
+	binary.LittleEndian.PutUint64(body[0:8], r.lsn)
+	binary.LittleEndian.PutUint64(body[8:16], r.txnID)
+	body[16] = r.op
+	binary.LittleEndian.PutUint32(body[17:21], uint32(len(r.key)))
+	binary.LittleEndian.PutUint32(body[21:25], uint32(len(r.val)))
+	copy(body[walRecordHeader:], r.key)
+	copy(body[walRecordHeader+len(r.key):], r.val)
+
+	out := make([]byte, 4+len(body)+4)
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(body)))
+	copy(out[4:], body)
+	binary.LittleEndian.PutUint32(out[4+len(body):], crc32.ChecksumIEEE(body))
+	return out
+}
+
+func decodeWALRecord(body []byte) walRecord {
+	klen := binary.LittleEndian.Uint32(body[17:21])
+	vlen := binary.LittleEndian.Uint32(body[21:25])
+	return walRecord{
+		lsn:   binary.LittleEndian.Uint64(body[0:8]),
+		txnID: binary.LittleEndian.Uint64(body[8:16]),
+		op:    body[16],
+		key:   body[walRecordHeader : walRecordHeader+klen],
+		val:   body[walRecordHeader+klen : walRecordHeader+klen+vlen],
+	}
+}
+
+// WAL is an append-only, fsynced log of committed mutations, used to
+// replay a DB's in-memory B+ tree after a crash.
+type WAL struct {
+	fd      *os.File
+	nextLSN uint64
+}
+
+func OpenWAL(path string) (*WAL, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("wal: open %s: %w", path, err)
+	}
+	return &WAL{fd: fd, nextLSN: 1}, nil
+}
+
+// AppendTxn writes every op of a transaction plus a trailing WAL_OP_COMMIT
+// marker as a single buffer, with exactly one fsync, and returns the
+// commit marker's lsn. Replay only applies a transaction once it has seen
+// that marker, so a crash that tears the write (even between two of this
+// same call's records) leaves the whole transaction un-applied rather
+// than partially applied.
+func (w *WAL) AppendTxn(txnID uint64, ops []pendingOp) (uint64, error) {
+	var buf []byte
+	for _, o := range ops {
+		buf = append(buf, encodeWALRecord(walRecord{lsn: w.nextLSN, txnID: txnID, op: o.op, key: o.key, val: o.val})...)
+		w.nextLSN++
+	}
+	commitLSN := w.nextLSN
+	buf = append(buf, encodeWALRecord(walRecord{lsn: commitLSN, txnID: txnID, op: WAL_OP_COMMIT})...)
+	w.nextLSN++
+
+	if _, err := w.fd.Seek(0, io.SeekEnd); err != nil {
+		return 0, fmt.Errorf("wal: seek: %w", err)
+	}
+	if _, err := w.fd.Write(buf); err != nil {
+		return 0, fmt.Errorf("wal: write: %w", err)
+	}
+	if err := w.fd.Sync(); err != nil {
+		return 0, fmt.Errorf("wal: fsync: %w", err)
+	}
+	return commitLSN, nil
+}
+
+// Replay reads every well-formed record, in order, and invokes fn for
+// each op (lsn > after) belonging to a transaction whose WAL_OP_COMMIT
+// marker was itself read intact. Ops are buffered per txnID until their
+// commit marker arrives; a transaction with no marker in the log (a torn
+// write from a crash mid-append, whether mid-record or between two of its
+// records) is discarded instead of being partially applied.
+//
+// It returns the lsn of the last commit marker that was applied (0 if
+// none), so the caller can advance its checkpoint past exactly the
+// transactions it just replayed.
+func (w *WAL) Replay(after uint64, fn func(walRecord)) (uint64, error) {
+	if _, err := w.fd.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("wal: seek: %w", err)
+	}
+	r := bufio.NewReader(w.fd)
+	pending := map[uint64][]walRecord{}
+	var lastCommitLSN uint64
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			break // EOF or a torn length prefix: nothing more to replay
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			break // torn record
+		}
+		var crcBuf [4]byte
+		if _, err := io.ReadFull(r, crcBuf[:]); err != nil {
+			break // torn crc
+		}
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(crcBuf[:]) {
+			break // torn or corrupt record; stop, don't trust what follows
+		}
+		rec := decodeWALRecord(body)
+		if rec.lsn+1 > w.nextLSN {
+			w.nextLSN = rec.lsn + 1
+		}
+		if rec.op == WAL_OP_COMMIT {
+			if rec.lsn > after {
+				for _, op := range pending[rec.txnID] {
+					fn(op)
+				}
+				lastCommitLSN = rec.lsn
+			}
+			delete(pending, rec.txnID)
+			continue
+		}
+		pending[rec.txnID] = append(pending[rec.txnID], rec)
+	}
+	return lastCommitLSN, nil
+}
+
+// Truncate empties the log after a successful checkpoint.
+func (w *WAL) Truncate() error {
+	if err := w.fd.Truncate(0); err != nil {
+		return fmt.Errorf("wal: truncate: %w", err)
+	}
+	_, err := w.fd.Seek(0, io.SeekStart)
+	return err
+}
+
+func (w *WAL) Close() error {
+	return w.fd.Close()
+}